@@ -0,0 +1,350 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	ole "github.com/go-ole/go-ole"
+	"github.com/go-ole/go-ole/oleutil"
+)
+
+// interestingTokenCount is how many of a message's most class-discriminating
+// tokens feed into classify, mirroring the "15 most interesting words"
+// convention from Paul Graham/Robinson style spam filters.
+const interestingTokenCount = 15
+
+// Model is a trained -classify model: for each class, how many training
+// documents for that class contained a given token. It generalizes the
+// classic ham/spam word counter to an arbitrary set of classes.
+type Model struct {
+	Classes map[string]map[string]int `json:"classes"`
+}
+
+func newModel() *Model {
+	return &Model{Classes: map[string]map[string]int{}}
+}
+
+func loadModel(path string) (*Model, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := newModel()
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *Model) save(path string) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// add records one training document's tokens (already deduplicated by the
+// caller) against class.
+func (m *Model) add(class string, tokens []string) {
+	words := m.Classes[class]
+	if words == nil {
+		words = map[string]int{}
+		m.Classes[class] = words
+	}
+	for _, t := range tokens {
+		words[t]++
+	}
+}
+
+var tokenRe = regexp.MustCompile(`[a-zA-Z0-9]+`)
+
+// tokenize lowercases text and returns its unique word tokens; Bayesian
+// classifiers care whether a word appears in a document, not how often.
+func tokenize(text string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, t := range tokenRe.FindAllString(strings.ToLower(text), -1) {
+		if len(t) < 3 || seen[t] {
+			continue
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	return out
+}
+
+// tokenizeMIME tokenizes the Subject header plus the decoded text/plain body
+// of a converted MIME message. It decodes quoted-printable/base64 transfer
+// encoding and skips attachments so the tokens it yields match what
+// trainFromFolder sees from Outlook's decoded plain-text Body property;
+// without that, training and classifying would key on different
+// representations of the same message.
+func tokenizeMIME(data []byte) []string {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return tokenize(string(data))
+	}
+	body := plainTextBody(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	return tokenize(msg.Header.Get("Subject") + " " + body)
+}
+
+// plainTextBody walks a (possibly multipart) MIME body and concatenates its
+// text/plain parts, decoding quoted-printable/base64 transfer encoding along
+// the way. Attachments and non-text/plain parts (HTML alternatives, images,
+// etc.) are skipped so they can't pollute the token distribution.
+func plainTextBody(contentType, transferEncoding string, r io.Reader) string {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		if mediaType != "" && mediaType != "text/plain" {
+			return ""
+		}
+		return decodeBody(transferEncoding, r)
+	}
+
+	mr := multipart.NewReader(r, params["boundary"])
+	var body strings.Builder
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		if disp, _, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition")); disp == "attachment" {
+			continue
+		}
+		body.WriteString(plainTextBody(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part))
+		body.WriteString(" ")
+	}
+	return body.String()
+}
+
+// decodeBody applies the Content-Transfer-Encoding named by transferEncoding
+// (quoted-printable or base64; anything else, including "", passes through
+// unchanged) and returns the decoded bytes as a string.
+func decodeBody(transferEncoding string, r io.Reader) string {
+	switch strings.ToLower(strings.TrimSpace(transferEncoding)) {
+	case "quoted-printable":
+		r = quotedprintable.NewReader(r)
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, r)
+	}
+	data, _ := io.ReadAll(r)
+	return string(data)
+}
+
+// wordTotal is how many training documents, across all classes, contained
+// word.
+func (m *Model) wordTotal(word string) int {
+	total := 0
+	for _, words := range m.Classes {
+		total += words[word]
+	}
+	return total
+}
+
+// wordClassProb estimates P(class | word) from training counts, Laplace
+// smoothed toward a uniform prior (1/nClasses) so rare words don't swing
+// the score on a single training document. total is word's wordTotal,
+// passed in so callers scoring the same word against every class don't
+// recompute it each time.
+func (m *Model) wordClassProb(word, class string, total int) float64 {
+	nClasses := len(m.Classes)
+	if nClasses == 0 {
+		return 0
+	}
+	uniform := 1 / float64(nClasses)
+	if total == 0 {
+		return uniform
+	}
+
+	const strength = 1.0 // weight given to the uniform prior, in "virtual documents"
+	raw := float64(m.Classes[class][word]) / float64(total)
+	return (strength*uniform + float64(total)*raw) / (strength + float64(total))
+}
+
+// interestingTokens picks the n tokens whose class probabilities deviate
+// most from the uniform prior, i.e. the ones that best discriminate
+// between classes, same role as "most interesting words" in spam filters.
+func (m *Model) interestingTokens(tokens []string, n int) []string {
+	nClasses := len(m.Classes)
+	if nClasses == 0 {
+		return nil
+	}
+	uniform := 1 / float64(nClasses)
+
+	type scored struct {
+		token string
+		dist  float64
+	}
+	scores := make([]scored, 0, len(tokens))
+	for _, t := range tokens {
+		total := m.wordTotal(t)
+		dist := 0.0
+		for class := range m.Classes {
+			if d := math.Abs(m.wordClassProb(t, class, total) - uniform); d > dist {
+				dist = d
+			}
+		}
+		scores = append(scores, scored{t, dist})
+	}
+
+	sort.Slice(scores, func(i, j int) bool { return scores[i].dist > scores[j].dist })
+	if len(scores) > n {
+		scores = scores[:n]
+	}
+
+	out := make([]string, len(scores))
+	for i, s := range scores {
+		out[i] = s.token
+	}
+	return out
+}
+
+// inverseChiSquare is the chi-square upper-tail probability for an even
+// number of degrees of freedom, the closed form Robinson's combining
+// method relies on instead of numerical integration.
+func inverseChiSquare(chi float64, df int) float64 {
+	m := chi / 2
+	sum := math.Exp(-m)
+	term := sum
+	for i := 1; i < df/2; i++ {
+		term *= m / float64(i)
+		sum += term
+	}
+	return math.Min(sum, 1.0)
+}
+
+// robinsonFisher combines independent per-token probabilities into a single
+// score via Robinson's inverse chi-square method: -2*sum(ln p_i) is
+// chi-square distributed with 2n degrees of freedom under independence.
+func robinsonFisher(probs []float64) float64 {
+	chi := 0.0
+	for _, p := range probs {
+		if p <= 0 {
+			p = 1e-9
+		}
+		chi += math.Log(p)
+	}
+	return inverseChiSquare(-2*chi, 2*len(probs))
+}
+
+// classify tokenizes a converted MIME message and returns whichever
+// trained class scores highest under robinsonFisher, or "" if the model
+// has no classes.
+func (m *Model) classify(data []byte) string {
+	if len(m.Classes) == 0 {
+		return ""
+	}
+
+	tokens := m.interestingTokens(tokenizeMIME(data), interestingTokenCount)
+	if len(tokens) == 0 {
+		return ""
+	}
+
+	totals := make([]int, len(tokens))
+	for i, t := range tokens {
+		totals[i] = m.wordTotal(t)
+	}
+
+	var best string
+	var bestScore float64
+	for class := range m.Classes {
+		probs := make([]float64, len(tokens))
+		for i, t := range tokens {
+			probs[i] = m.wordClassProb(t, class, totals[i])
+		}
+		if score := robinsonFisher(probs); best == "" || score > bestScore {
+			best, bestScore = class, score
+		}
+	}
+	return best
+}
+
+// trainClassifier walks spec ("class:folderName[,class:folderName...]"),
+// finds each named folder by exact Name match, and trains m on the
+// Subject+Body of every item in it.
+func trainClassifier(folders []*Folder, spec string) (*Model, error) {
+	m := newModel()
+
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		i := strings.Index(pair, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("bad -train entry %q, want class:folderName", pair)
+		}
+		class, folderName := pair[:i], pair[i+1:]
+
+		var folder *Folder
+		for _, f := range folders {
+			if f.Name == folderName {
+				folder = f
+				break
+			}
+		}
+		if folder == nil {
+			return nil, fmt.Errorf("-train folder %q not found", folderName)
+		}
+
+		n, err := trainFromFolder(m, folder, class)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Trained %d items from %s as %s\n", n, folder.Path, class)
+	}
+
+	return m, nil
+}
+
+func trainFromFolder(m *Model, folder *Folder, class string) (trained int, err error) {
+	items := oleutil.MustCallMethod(folder.IDispatch, "Items").ToIDispatch()
+	defer items.Release()
+
+	total := oleutil.MustGetProperty(items, "Count").Value().(int32)
+	for i := 1; i <= int(total); i++ {
+		item, err := oleutil.GetProperty(items, "Item", i)
+		if err != nil || item.VT != ole.VT_DISPATCH {
+			continue
+		}
+
+		obj := item.ToIDispatch()
+
+		var subject, body string
+		if value, err := oleutil.GetProperty(obj, "Subject"); err == nil {
+			subject, _ = value.Value().(string)
+		}
+		if value, err := oleutil.GetProperty(obj, "Body"); err == nil {
+			body, _ = value.Value().(string)
+		}
+
+		obj.Release()
+
+		if tokens := tokenize(subject + " " + body); len(tokens) > 0 {
+			m.add(class, tokens)
+			trained++
+		}
+	}
+	return trained, nil
+}
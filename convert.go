@@ -1,3 +1,4 @@
+//go:build windows
 // +build windows
 
 package main
@@ -11,9 +12,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
-	"runtime/debug"
 	"sort"
-	"strings"
 	"syscall"
 	"time"
 	"unsafe"
@@ -28,23 +27,28 @@ var (
 	IID_IMessage            = ole.NewGUID("{00020307-0000-0000-C000-000000000046}")
 	IID_IStream             = ole.NewGUID("{0000000C-0000-0000-C000-000000000046}")
 
-	modole32, _               = syscall.LoadDLL("ole32.dll")
-	pCreateStreamOnHGlobal, _ = modole32.FindProc("CreateStreamOnHGlobal")
-	pGetHGlobalFromStream, _  = modole32.FindProc("GetHGlobalFromStream")
-	pCoCreateInstance, _      = modole32.FindProc("CoCreateInstance")
+	modole32, _          = syscall.LoadDLL("ole32.dll")
+	pCoCreateInstance, _ = modole32.FindProc("CoCreateInstance")
 
 	modmapi32, _       = syscall.LoadDLL("mapi32.dll")
 	pMAPIInitialize, _ = modmapi32.FindProc("MAPIInitialize")
 	pMAPILogonEx, _    = modmapi32.FindProc("MAPILogonEx")
 
-	modkernel32, _   = syscall.LoadDLL("kernel32.dll")
-	pGlobalLock, _   = modkernel32.FindProc("GlobalLock")
-	pGlobalUnlock, _ = modkernel32.FindProc("GlobalUnlock")
-
 	listFolders    = flag.Bool("list", false, "list folders")
 	useAddressBook = flag.Bool("ab", false, "use addressbook to translate email address")
 
 	saveFolder      = flag.String("folder", "", "folder name to save")
+	recursive       = flag.Bool("recursive", false, "also export subfolders of -folder")
+	includePat      = flag.String("include", "", "only export folders whose path (store name stripped) matches this glob (e.g. Inbox/**)")
+	excludePat      = flag.String("exclude", "", "skip folders whose path (store name stripped) matches this glob (e.g. **/Junk*)")
+	skipClasses     = flag.String("skip-classes", "IPM.Schedule.Meeting.Resp.*", "comma separated MessageClass globs to skip")
+	outputFormat    = flag.String("format", "mmdf", "output format: mmdf, mbox, maildir, eml")
+	maildirColon    = flag.Bool("maildir-colon", false, "use the standard Maildir \":2,\" flag separator (required by mu/notmuch/Dovecot-on-Unix) instead of the NTFS-safe \";2,\"; only safe when -dir isn't on an NTFS volume")
+	headerSets      = flag.String("headers", "outlook,imap", "X- header sets to add to each message: outlook, imap, none")
+	incremental     = flag.Bool("incremental", false, "skip messages already exported, per the -state index")
+	statePath       = flag.String("state", "", "state file for -incremental (default: <dir>/.outlook2mbox-state.json)")
+	classifyPath    = flag.String("classify", "", "route messages into dir/<class>/... using this trained model")
+	trainSpec       = flag.String("train", "", "train mode: class:folderName[,class:folderName...], written to -classify")
 	targetDirectory = flag.String("dir", ".", "target directory to save")
 	count           = flag.Int("count", 1000, "total emails to save")
 	startDate       = flag.String("startdate", "", "start date of emails to save (e.g., 20060102)")
@@ -106,20 +110,47 @@ func main() {
 		}
 	}
 
-	if *saveFolder == "" {
+	if *trainSpec != "" {
+		if *classifyPath == "" {
+			log.Printf("-train requires -classify=path to write the trained model to\n")
+			return
+		}
+		model, err := trainClassifier(folders, *trainSpec)
+		if err != nil {
+			log.Printf("trainClassifier: %v\n", err)
+			return
+		}
+		if err := model.save(*classifyPath); err != nil {
+			log.Printf("Failed to save model %s: %v\n", *classifyPath, err)
+		}
 		return
 	}
 
-	var folder *Folder
-	for _, f := range folders {
-		if f.Name == *saveFolder {
-			folder = f
-			break
+	if *saveFolder == "" && *includePat == "" {
+		return
+	}
+
+	var root *Folder
+	if *saveFolder != "" {
+		for _, f := range folders {
+			if f.Name == *saveFolder {
+				root = f
+				break
+			}
+		}
+		if root == nil {
+			log.Printf("Folder %s not found\n", *saveFolder)
+			return
 		}
 	}
 
-	if folder == nil {
-		log.Printf("Folder %s not found\n", *saveFolder)
+	matched, err := matchFolders(folders, root, *recursive, *includePat, *excludePat)
+	if err != nil {
+		log.Printf("Bad -include/-exclude pattern: %v\n", err)
+		return
+	}
+	if len(matched) == 0 {
+		log.Printf("No folders matched\n")
 		return
 	}
 
@@ -138,13 +169,55 @@ func main() {
 		return
 	}
 
-	var stm *IStream
-	hr, _, _ := pCreateStreamOnHGlobal.Call(uintptr(0), uintptr(0), uintptr(unsafe.Pointer(&stm)))
-	if hr != 0 {
-		log.Printf("CreateStreamOnHGlobal: %v\n", ole.NewError(hr))
-		return
+	stm := newGoStream()
+
+	var state *State
+	if *incremental {
+		sp := *statePath
+		if sp == "" {
+			sp = filepath.Join(*targetDirectory, ".outlook2mbox-state.json")
+		}
+		if state, err = loadState(sp); err != nil {
+			log.Printf("loadState: %v\n", err)
+			return
+		}
+	}
+
+	var classifier *Model
+	if *classifyPath != "" {
+		if classifier, err = loadModel(*classifyPath); err != nil {
+			log.Printf("loadModel: %v\n", err)
+			return
+		}
+	}
+	classSinks := map[string]Sink{}
+
+	saved := 0
+	for _, folder := range matched {
+		saved += exportFolder(folder, converter, stm, state, classifier, classSinks)
+	}
+
+	for _, s := range classSinks {
+		s.Close()
 	}
 
+	if state != nil {
+		if err := state.Flush(); err != nil {
+			log.Printf("Failed to save state %s: %v\n", state.path, err)
+		}
+	}
+
+	log.Printf("%d emails saved\n", saved)
+}
+
+// exportFolder streams every item in folder through extractMessageData and
+// into a Sink rooted at -dir, mirroring folder's path in the Outlook tree.
+// When state is non-nil, items already recorded for this folder are
+// skipped and newly written ones are added to it. When classifier is
+// non-nil, messages are routed by classifier.classify into per-class sinks
+// in classSinks (shared and lazily populated across all exported folders)
+// instead of into a sink for this folder.
+func exportFolder(folder *Folder, converter *IConverterSession, stm *goStream, state *State, classifier *Model, classSinks map[string]Sink) (saved int) {
 	items := oleutil.MustCallMethod(folder.IDispatch, "Items").ToIDispatch()
 	defer items.Release()
 
@@ -167,16 +240,29 @@ func main() {
 			if pos, err := findFirstItemAfter(items, int(total), d.Unix()); err == nil {
 				log.Printf("Stopping by %d for %s\n", pos, *endDate)
 				xend = pos
-				*count = xend - xstart
 			}
 		}
 	}
 
-	log.Printf("Folder %s: total %d items, from: %d, to: %d, count: %d\n", folder.Name, total, xstart, xend, *count)
+	log.Printf("Folder %s: total %d items, from: %d, to: %d\n", folder.Path, total, xstart, xend)
 
-	saved := 0
+	var sink Sink
+	if classifier == nil {
+		outDir := folderOutputDir(*targetDirectory, folder)
+		if err := os.MkdirAll(outDir, 0755); err != nil {
+			log.Printf("Failed to make dir for %s: %v\n", outDir, err)
+			return 0
+		}
+
+		s, err := newSink(*outputFormat, outDir, folder.Name, *maildirColon)
+		if err != nil {
+			log.Printf("newSink: %v\n", err)
+			return 0
+		}
+		defer s.Close()
+		sink = s
+	}
 
-	var fout *File
 	for i := xstart; i < xend; {
 		if i >= int(total) {
 			break
@@ -186,20 +272,32 @@ func main() {
 		item, err := oleutil.GetProperty(items, "Item", idx)
 		if err != nil || item.VT != ole.VT_DISPATCH {
 			log.Printf("Failed to get Item %d: %v\n", idx, err)
+			i += 1
 			continue
 		}
 
 		obj := item.ToIDispatch()
-		data, ts, err, stop := extractMessageData(obj, converter, stm)
+
+		var mclass, entryID string
+		if value, err := oleutil.GetProperty(obj, "MessageClass"); err == nil {
+			mclass, _ = value.Value().(string)
+		}
+		if value, err := oleutil.GetProperty(obj, "EntryID"); err == nil {
+			entryID, _ = value.Value().(string)
+		}
+		if skipClassMatches(mclass, *skipClasses) || (state != nil && entryID != "" && state.Has(folder.Path, entryID)) {
+			obj.Release()
+			i += 1
+			continue
+		}
+
+		data, ts, flags, err, stop := extractMessageData(obj, converter, stm)
 
 		if err != nil {
-			var subject, mclass string
+			var subject string
 			if value, err := oleutil.GetProperty(obj, "Subject"); err == nil {
 				subject, _ = value.Value().(string)
 			}
-			if value, err := oleutil.GetProperty(obj, "MessageClass"); err == nil {
-				mclass, _ = value.Value().(string)
-			}
 
 			log.Printf("Failed to extract data for %d %s (%s): %v\n", i, subject, mclass, err)
 		}
@@ -216,28 +314,56 @@ func main() {
 			continue
 		}
 
-		if fout != nil && !ts.IsZero() && !equalMonth(ts, fout.timestamp) {
-			fout.Close()
-			debug.FreeOSMemory()
-			fout = nil
+		dest := sink
+		if classifier != nil {
+			class := classifier.classify(data)
+			if class == "" {
+				class = "unclassified"
+			}
+			s, err := classSink(classSinks, *targetDirectory, class)
+			if err != nil {
+				log.Printf("classSink %s: %v\n", class, err)
+				continue
+			}
+			dest = s
 		}
 
-		if fout == nil {
-			if fout, err = newFile(*targetDirectory, folder.Name, ts); err != nil {
-				break
-			}
+		path, err := dest.Write(data, ts, flags)
+		if err != nil {
+			log.Printf("Failed to write item %d: %v\n", idx, err)
+			continue
+		}
+		if state != nil && entryID != "" {
+			state.Record(folder.Path, entryID, StateEntry{CreationTime: ts, Size: len(data), MD5: md5Sum(data), Archive: path})
 		}
-		fout.zf.Write(POSTMARK)
-		fout.zf.Write(data)
-		fout.zf.Write(POSTMARK)
 		saved += 1
 	}
 
-	if fout != nil {
-		fout.Close()
+	return saved
+}
+
+// classSink returns the Sink for class in classSinks, creating (and
+// caching) a fresh one rooted at base/class the first time class is seen.
+// Folders are processed one at a time in ascending-month order, so a class
+// archive's archiveSink can rotate back to a month file it already wrote in
+// an earlier folder once a later folder's messages reach it; newFile
+// reopens that file O_APPEND, so the earlier folder's messages survive as
+// their own gzip member instead of being overwritten.
+func classSink(classSinks map[string]Sink, base, class string) (Sink, error) {
+	if s, ok := classSinks[class]; ok {
+		return s, nil
 	}
 
-	log.Printf("%d emails saved\n", saved)
+	outDir := filepath.Join(base, class)
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return nil, err
+	}
+	s, err := newSink(*outputFormat, outDir, class, *maildirColon)
+	if err != nil {
+		return nil, err
+	}
+	classSinks[class] = s
+	return s, nil
 }
 
 type File struct {
@@ -266,10 +392,18 @@ func (f *File) Close() {
 	f.path = ""
 }
 
-func newFile(dir, name string, ts time.Time) (f *File, err error) {
-	fname := fmt.Sprintf("%s_%s.mmdf.gz", name, ts.Format("200601"))
+func newFile(dir, name string, ts time.Time, format archiveFormat) (f *File, err error) {
+	ext := "mmdf"
+	if format == formatMbox {
+		ext = "mbox"
+	}
+	fname := fmt.Sprintf("%s_%s.%s.gz", name, ts.Format("200601"), ext)
 	fpath := filepath.Join(dir, fname)
-	fout, err := os.OpenFile(fpath, os.O_RDWR|os.O_CREATE, 0644)
+	// O_APPEND (not O_RDWR) so reopening a month file that -incremental or a
+	// prior run already wrote to adds a new gzip member after the existing
+	// bytes instead of overwriting them from offset 0; gzip readers handle
+	// concatenated members transparently, so this stays a valid .gz file.
+	fout, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
 	if err != nil {
 		log.Printf("Failed to open file %s: %v\n", fpath, err)
 		return nil, err
@@ -601,15 +735,10 @@ func findFirstItemAfter(items *ole.IDispatch, count int, timestamp int64) (idx i
 	return
 }
 
-func extractMessageData(obj *ole.IDispatch, converter *IConverterSession, stm *IStream) (data []byte, ts time.Time, err error, stop bool) {
+func extractMessageData(obj *ole.IDispatch, converter *IConverterSession, stm *goStream) (data []byte, ts time.Time, flags MessageFlags, err error, stop bool) {
 	const CCSF_SMTP = 2
 
-	stm.Seek(0, 0)
-
-	value, err := oleutil.GetProperty(obj, "MessageClass")
-	if err == nil && strings.HasPrefix(value.Value().(string), "IPM.Schedule.Meeting.Resp.") {
-		return
-	}
+	stm.Reset()
 
 	if value, err := oleutil.GetProperty(obj, "CreationTime"); err == nil && value.VT == ole.VT_DATE {
 		if t, ok := value.Value().(time.Time); ok {
@@ -617,7 +746,23 @@ func extractMessageData(obj *ole.IDispatch, converter *IConverterSession, stm *I
 		}
 	}
 
-	value, err = oleutil.GetProperty(obj, "MAPIOBJECT")
+	if value, err := oleutil.GetProperty(obj, "UnRead"); err == nil {
+		if b, ok := value.Value().(bool); ok {
+			flags.UnRead = b
+		}
+	}
+	if value, err := oleutil.GetProperty(obj, "FlagStatus"); err == nil {
+		if n, ok := value.Value().(int32); ok {
+			flags.FlagStatus = n
+		}
+	}
+	if value, err := oleutil.GetProperty(obj, "LastVerbExecuted"); err == nil {
+		if n, ok := value.Value().(int32); ok {
+			flags.LastVerbExecuted = n
+		}
+	}
+
+	value, err := oleutil.GetProperty(obj, "MAPIOBJECT")
 	if err != nil {
 		log.Printf("Get MAPIOBJECT: %v\n", err)
 		stop = true
@@ -635,35 +780,16 @@ func extractMessageData(obj *ole.IDispatch, converter *IConverterSession, stm *I
 	}
 	defer imsg.Release()
 
-	err = converter.MAPIToMIMEStm(imsg, stm, CCSF_SMTP)
+	err = converter.MAPIToMIMEStm(imsg, stm.asIStream(), CCSF_SMTP)
 	if err != nil {
 		log.Printf("MAPIToMIMEStm: %v\n", err)
 		return
 	}
 
-	var size uint64
-	size, err = stm.Seek(0, 1)
-	if err != nil || size <= 0 {
-		log.Printf("Seek: %v\n", err)
-		return
-	}
-
-	var handle uintptr
-	hr, _, _ := pGetHGlobalFromStream.Call(uintptr(unsafe.Pointer(stm)), uintptr(unsafe.Pointer(&handle)))
-	if hr != 0 {
-		log.Printf("GetHGlobalFromStream: %v\n", ole.NewError(hr))
-		return
-	}
-
-	addr, _, _ := pGlobalLock.Call(handle)
-	if addr == 0 {
-		log.Println("Unable to GlobalLock")
+	if stm.buf.Len() == 0 {
 		return
 	}
-	defer pGlobalUnlock.Call(handle)
 
-	buf := (*[1 << 30]byte)(unsafe.Pointer(uintptr(addr)))[0:size]
-	data = make([]byte, size)
-	copy(data, buf)
+	data = injectHeaders(stm.Bytes(), obj, flags, *headerSets)
 	return
 }
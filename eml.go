@@ -0,0 +1,36 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// emlSink writes each message as a standalone .eml file into a flat
+// directory, for clients that want one file per message without a
+// Maildir envelope.
+type emlSink struct {
+	dir string
+	seq int
+}
+
+func newEmlSink(targetDir, name string) (*emlSink, error) {
+	dir := filepath.Join(targetDir, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &emlSink{dir: dir}, nil
+}
+
+func (s *emlSink) Write(data []byte, ts time.Time, flags MessageFlags) (string, error) {
+	s.seq++
+	fname := fmt.Sprintf("%06d.eml", s.seq)
+	fpath := filepath.Join(s.dir, fname)
+	return fpath, os.WriteFile(fpath, data, 0644)
+}
+
+func (s *emlSink) Close() {}
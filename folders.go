@@ -0,0 +1,135 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// skipClassMatches reports whether mclass matches any of the comma
+// separated MessageClass globs in patterns (e.g. -skip-classes's default
+// "IPM.Schedule.Meeting.Resp.*").
+func skipClassMatches(mclass, patterns string) bool {
+	for _, pat := range strings.Split(patterns, ",") {
+		pat = strings.TrimSpace(pat)
+		if pat == "" {
+			continue
+		}
+		if ok, _ := filepath.Match(pat, mclass); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// globToRegexp converts a shell-style glob into an anchored regexp, where
+// "**" matches across path separators and a lone "*" does not.
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteByte('^')
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString(".")
+		default:
+			if strings.ContainsRune(`.+()|[]{}^$\`, rune(c)) {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('$')
+	return regexp.Compile(b.String())
+}
+
+// matchFolders selects which folders to export. If root is non-nil, only
+// root (and, with recursive, its descendants) are considered; otherwise
+// every folder is a candidate, so -include alone can select a whole tree.
+// include/exclude, when set, are matched against the folder's path with
+// its leading store name stripped too (see folderMatchPath), so
+// "-include=Inbox/**" selects every store's Inbox subtree rather than
+// never matching.
+func matchFolders(folders []*Folder, root *Folder, recursive bool, include, exclude string) ([]*Folder, error) {
+	var includeRe, excludeRe *regexp.Regexp
+	var err error
+	if include != "" {
+		if includeRe, err = globToRegexp(include); err != nil {
+			return nil, err
+		}
+	}
+	if exclude != "" {
+		if excludeRe, err = globToRegexp(exclude); err != nil {
+			return nil, err
+		}
+	}
+
+	candidates := folders
+	if root != nil {
+		candidates = []*Folder{root}
+		if recursive {
+			candidates = append(candidates, folderDescendants(root)...)
+		}
+	}
+
+	var matched []*Folder
+	for _, f := range candidates {
+		path := folderMatchPath(f)
+		if includeRe != nil && !includeRe.MatchString(path) {
+			continue
+		}
+		if excludeRe != nil && excludeRe.MatchString(path) {
+			continue
+		}
+		matched = append(matched, f)
+	}
+	return matched, nil
+}
+
+func folderDescendants(f *Folder) (out []*Folder) {
+	for _, c := range f.Children {
+		out = append(out, c)
+		out = append(out, folderDescendants(c)...)
+	}
+	return
+}
+
+// folderRelPath is f.Path with its leading store separator (Outlook prefixes
+// FolderPath with "\\", not a single "\") and backslashes normalized to "/",
+// e.g. "\\Mailbox\Inbox\2024" -> "Mailbox/Inbox/2024".
+func folderRelPath(f *Folder) string {
+	return strings.ReplaceAll(strings.TrimLeft(f.Path, "\\"), "\\", "/")
+}
+
+// folderMatchPath is folderRelPath with the leading store-name segment also
+// stripped, e.g. "Mailbox/Inbox/2024" -> "Inbox/2024". -include/-exclude
+// patterns are matched against this, so "Inbox/**" selects every store's
+// Inbox subtree instead of requiring the store name up front.
+func folderMatchPath(f *Folder) string {
+	path := folderRelPath(f)
+	if i := strings.Index(path, "/"); i >= 0 {
+		return path[i+1:]
+	}
+	return ""
+}
+
+// folderOutputDir returns the directory a folder's archive files should be
+// written into, mirroring its Outlook path under base, e.g. a folder at
+// "Mailbox/Inbox/2024" is rooted at base/Mailbox/Inbox, with "2024" only
+// appearing as the archive filename prefix.
+func folderOutputDir(base string, f *Folder) string {
+	dir := filepath.Dir(folderRelPath(f))
+	if dir == "." {
+		return base
+	}
+	return filepath.Join(base, dir)
+}
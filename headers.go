@@ -0,0 +1,141 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	ole "github.com/go-ole/go-ole"
+	"github.com/go-ole/go-ole/oleutil"
+)
+
+// PR_MESSAGE_FLAGS (see MS-OXCMSG) as an Outlook named property tag.
+const prMessageFlagsTag = "http://schemas.microsoft.com/mapi/proptag/0x0E070003"
+
+// MSGFLAG_* bits read out of PR_MESSAGE_FLAGS.
+const (
+	msgflagRead   = 0x01
+	msgflagUnsent = 0x08
+)
+
+// injectHeaders adds X-Outlook-*, X-Keywords and X-Message-Flags headers to
+// a converted MIME message, driven by -headers (a comma separated list of
+// "outlook", "imap", "none"). MAPIToMIMEStm only emits what the MIME
+// converter understands, so this preserves Outlook-only metadata that would
+// otherwise be lost.
+func injectHeaders(data []byte, obj *ole.IDispatch, flags MessageFlags, sets string) []byte {
+	var headers []string
+	for _, set := range strings.Split(sets, ",") {
+		switch strings.TrimSpace(set) {
+		case "outlook":
+			headers = append(headers, outlookHeaders(obj)...)
+		case "imap":
+			headers = append(headers, imapHeaders(obj)...)
+		}
+	}
+
+	if len(headers) == 0 {
+		return data
+	}
+	return insertHeaders(data, headers)
+}
+
+func outlookHeaders(obj *ole.IDispatch) (headers []string) {
+	str := func(prop string) string {
+		if value, err := oleutil.GetProperty(obj, prop); err == nil {
+			if s, ok := value.Value().(string); ok {
+				return s
+			}
+		}
+		return ""
+	}
+
+	for _, h := range []struct{ prop, header string }{
+		{"ConversationID", "X-Outlook-ConversationID"},
+		{"ConversationTopic", "X-Outlook-ConversationTopic"},
+		{"EntryID", "X-Outlook-EntryID"},
+		{"SentOnBehalfOfName", "X-Outlook-SentOnBehalfOfName"},
+		{"ReceivedByName", "X-Outlook-ReceivedByName"},
+	} {
+		if v := str(h.prop); v != "" {
+			headers = append(headers, h.header+": "+v)
+		}
+	}
+
+	if value, err := oleutil.GetProperty(obj, "Importance"); err == nil {
+		if n, ok := value.Value().(int32); ok {
+			headers = append(headers, fmt.Sprintf("X-Outlook-Importance: %d", n))
+		}
+	}
+	if value, err := oleutil.GetProperty(obj, "Sensitivity"); err == nil {
+		if n, ok := value.Value().(int32); ok {
+			headers = append(headers, fmt.Sprintf("X-Outlook-Sensitivity: %d", n))
+		}
+	}
+	if value, err := oleutil.GetProperty(obj, "Categories"); err == nil {
+		if s, ok := value.Value().(string); ok && s != "" {
+			headers = append(headers, "X-Keywords: "+s)
+		}
+	}
+
+	return
+}
+
+// imapHeaders derives a UW-IMAP style X-Message-Flags header from
+// PR_MESSAGE_FLAGS, the same bitmask IMAP servers surface as \Seen,
+// \Answered, etc.: "R" (read) when MSGFLAG_READ is set, otherwise "O" (old),
+// followed by "S" (sent) unless MSGFLAG_UNSENT is set.
+func imapHeaders(obj *ole.IDispatch) (headers []string) {
+	pa, err := oleutil.CallMethod(obj, "PropertyAccessor")
+	if err != nil {
+		return
+	}
+	pacc := pa.ToIDispatch()
+	defer pacc.Release()
+
+	value, err := oleutil.CallMethod(pacc, "GetProperty", prMessageFlagsTag)
+	if err != nil {
+		return
+	}
+	n, ok := value.Value().(int32)
+	if !ok {
+		return
+	}
+
+	var flagstr strings.Builder
+	if n&msgflagRead != 0 {
+		flagstr.WriteByte('R')
+	} else {
+		flagstr.WriteByte('O')
+	}
+	if n&msgflagUnsent == 0 {
+		flagstr.WriteByte('S')
+	}
+	headers = append(headers, "X-Message-Flags: "+flagstr.String())
+	return
+}
+
+// insertHeaders splices extra header lines in just before the blank line
+// that ends a message's header block.
+func insertHeaders(data []byte, headers []string) []byte {
+	sep := []byte("\r\n\r\n")
+	idx := bytes.Index(data, sep)
+	if idx < 0 {
+		sep = []byte("\n\n")
+		idx = bytes.Index(data, sep)
+	}
+	if idx < 0 {
+		return data
+	}
+
+	extra := strings.Join(headers, "\r\n")
+	out := make([]byte, 0, len(data)+len(extra)+2)
+	out = append(out, data[:idx]...)
+	out = append(out, "\r\n"...)
+	out = append(out, extra...)
+	out = append(out, data[idx:]...)
+	return out
+}
@@ -0,0 +1,74 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// maildirSink writes one file per message into a Maildir tree
+// (cur/, new/, tmp/) rooted at dir/name, named per the Maildir spec.
+type maildirSink struct {
+	dir        string
+	seq        int
+	host       string
+	colonFlags bool
+}
+
+// newMaildirSink builds a maildirSink rooted at targetDir/name. colonFlags
+// selects the standard Maildir ":2," info-suffix separator, as required by
+// Unix readers like mu/notmuch/Dovecot-on-Unix; when false (the default) the
+// NTFS-safe ";2," separator is used instead, since -dir is expected to be on
+// an NTFS volume on this Windows-only build.
+func newMaildirSink(targetDir, name string, colonFlags bool) (*maildirSink, error) {
+	dir := filepath.Join(targetDir, name)
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	return &maildirSink{dir: dir, host: host, colonFlags: colonFlags}, nil
+}
+
+func (s *maildirSink) Write(data []byte, ts time.Time, flags MessageFlags) (string, error) {
+	s.seq++
+
+	delivery := ts
+	if delivery.IsZero() {
+		delivery = time.Now()
+	}
+
+	// Per the Maildir spec, the "<sep>2,<flags>" info suffix only belongs on
+	// messages in cur/; new/ holds plain, unseen deliveries. The standard
+	// separator is ":", but ":" is also a reserved NTFS character that turns
+	// the suffix into an alternate data stream name, silently dropping the
+	// message body — so on NTFS (the default for this Windows-only build)
+	// we use the Courier/Dovecot-on-Windows ";2," convention instead. Unix
+	// readers (mu, notmuch, Dovecot-on-Unix) require the real ":2," and
+	// won't parse ";2,", so -maildir-colon switches back to it for trees
+	// that aren't stored on NTFS (e.g. a mapped Unix share).
+	sep := ";"
+	if s.colonFlags {
+		sep = ":"
+	}
+	uniq := fmt.Sprintf("%d.%d_%d.%s", delivery.Unix(), os.Getpid(), s.seq, s.host)
+	sub := "new"
+	fname := uniq
+	if flagStr := flags.maildirFlags(); flagStr != "" {
+		sub = "cur"
+		fname = uniq + sep + "2," + flagStr
+	}
+	fpath := filepath.Join(s.dir, sub, fname)
+	return fpath, os.WriteFile(fpath, data, 0644)
+}
+
+func (s *maildirSink) Close() {}
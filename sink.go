@@ -0,0 +1,176 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// MessageFlags carries the subset of Outlook message state that a Sink may
+// want to encode in the message it writes (e.g. Maildir's cur/ info suffix).
+type MessageFlags struct {
+	UnRead           bool
+	FlagStatus       int32
+	LastVerbExecuted int32
+}
+
+// OlFlagStatus values (see MSDN OlFlagStatus).
+const (
+	olNoFlag   = 0
+	olComplete = 1
+	olMarked   = 2
+)
+
+// OlLastVerbExecuted values (see MSDN OlLastVerbExecuted) that we care about
+// for distinguishing replied/forwarded messages.
+const (
+	olReplied    = 102
+	olRepliedAll = 103
+	olForwarded  = 104
+)
+
+// Sink receives converted MIME messages one at a time and is responsible for
+// laying them out on disk in whatever format was requested via -format. It
+// returns the path of the file the message was written into, so callers
+// (e.g. the -incremental state index) can record where to find it again.
+type Sink interface {
+	Write(data []byte, ts time.Time, flags MessageFlags) (path string, err error)
+	Close()
+}
+
+// archiveFormat selects the framing used by archiveSink.
+type archiveFormat int
+
+const (
+	formatMMDF archiveFormat = iota
+	formatMbox
+)
+
+// archiveSink writes messages into per-month gzip archives, exactly as the
+// original mmdf-only code path did, but now also supports classic mbox
+// framing.
+type archiveSink struct {
+	dir, name string
+	format    archiveFormat
+	cur       *File
+}
+
+func newArchiveSink(dir, name string, format archiveFormat) *archiveSink {
+	return &archiveSink{dir: dir, name: name, format: format}
+}
+
+func (s *archiveSink) Write(data []byte, ts time.Time, flags MessageFlags) (string, error) {
+	if s.cur != nil && !ts.IsZero() && !equalMonth(ts, s.cur.timestamp) {
+		s.cur.Close()
+		debug.FreeOSMemory()
+		s.cur = nil
+	}
+
+	if s.cur == nil {
+		f, err := newFile(s.dir, s.name, ts, s.format)
+		if err != nil {
+			return "", err
+		}
+		s.cur = f
+	}
+
+	if s.format == formatMbox {
+		writeMboxFrame(s.cur.zf, data, ts)
+	} else {
+		s.cur.zf.Write(POSTMARK)
+		s.cur.zf.Write(data)
+		s.cur.zf.Write(POSTMARK)
+	}
+	return s.cur.path, nil
+}
+
+func (s *archiveSink) Close() {
+	if s.cur != nil {
+		s.cur.Close()
+		s.cur = nil
+	}
+}
+
+// writeMboxFrame writes one message using classic "From " mbox framing,
+// quoting any body/header line that would otherwise look like an envelope
+// separator.
+func writeMboxFrame(w io.Writer, data []byte, ts time.Time) {
+	date := ts
+	if date.IsZero() {
+		date = time.Now()
+	}
+	fmt.Fprintf(w, "From %s %s\n", extractFromAddress(data), date.Format("Mon Jan 2 15:04:05 2006"))
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "From ") {
+			io.WriteString(w, ">")
+		}
+		io.WriteString(w, line)
+		io.WriteString(w, "\n")
+	}
+	io.WriteString(w, "\n")
+}
+
+// extractFromAddress pulls the bare address out of the MIME "From:" header,
+// falling back to MAILER-DAEMON when one can't be found.
+func extractFromAddress(data []byte) string {
+	headers := string(data)
+	if i := strings.Index(headers, "\r\n\r\n"); i >= 0 {
+		headers = headers[:i]
+	}
+
+	for _, hline := range strings.Split(headers, "\n") {
+		if !strings.HasPrefix(hline, "From:") {
+			continue
+		}
+		addr := strings.TrimSpace(strings.TrimPrefix(hline, "From:"))
+		if i := strings.Index(addr, "<"); i >= 0 {
+			if j := strings.Index(addr, ">"); j > i {
+				return addr[i+1 : j]
+			}
+		}
+		return addr
+	}
+	return "MAILER-DAEMON"
+}
+
+// maildirFlags renders the Outlook read/flagged/replied state as the flag
+// letters of a Maildir ";2," info suffix (flag letters must stay in ASCII
+// order); an empty result means the message belongs in new/ unflagged.
+func (f MessageFlags) maildirFlags() string {
+	var b strings.Builder
+	if f.FlagStatus == olMarked {
+		b.WriteByte('F')
+	}
+	switch f.LastVerbExecuted {
+	case olReplied, olRepliedAll:
+		b.WriteByte('R')
+	}
+	if !f.UnRead {
+		b.WriteByte('S')
+	}
+	return b.String()
+}
+
+// newSink builds the Sink selected by -format. colonFlags selects the
+// Maildir info-suffix separator (see -maildir-colon) and is ignored by
+// every format but maildir.
+func newSink(format, dir, name string, colonFlags bool) (Sink, error) {
+	switch format {
+	case "", "mmdf":
+		return newArchiveSink(dir, name, formatMMDF), nil
+	case "mbox":
+		return newArchiveSink(dir, name, formatMbox), nil
+	case "maildir":
+		return newMaildirSink(dir, name, colonFlags)
+	case "eml":
+		return newEmlSink(dir, name)
+	default:
+		return nil, fmt.Errorf("unknown -format %q", format)
+	}
+}
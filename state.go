@@ -0,0 +1,96 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// StateEntry records enough about an already-exported message to recognize
+// it on a later run and to know which archive file it landed in.
+type StateEntry struct {
+	CreationTime time.Time
+	Size         int
+	MD5          string
+	Archive      string
+}
+
+// State is the on-disk -incremental index: folder path -> EntryID -> the
+// StateEntry written for it. It lets repeated runs skip messages already
+// exported and resume safely after a crash mid-folder, instead of
+// rewriting (and duplicating into) the whole month's archive.
+type State struct {
+	path    string
+	Folders map[string]map[string]StateEntry
+
+	unsaved int
+}
+
+func loadState(path string) (*State, error) {
+	s := &State{path: path, Folders: map[string]map[string]StateEntry{}}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.Folders); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *State) Has(folderPath, entryID string) bool {
+	_, ok := s.Folders[folderPath][entryID]
+	return ok
+}
+
+func (s *State) Record(folderPath, entryID string, entry StateEntry) {
+	if s.Folders[folderPath] == nil {
+		s.Folders[folderPath] = map[string]StateEntry{}
+	}
+	s.Folders[folderPath][entryID] = entry
+	s.unsaved++
+
+	const flushEvery = 20
+	if s.unsaved >= flushEvery {
+		if err := s.Flush(); err != nil {
+			log.Printf("Failed to save state %s: %v\n", s.path, err)
+		}
+	}
+}
+
+// Flush writes the state to disk if there are unsaved changes, via a
+// rename so a crash mid-write can't corrupt the existing index.
+func (s *State) Flush() error {
+	if s.unsaved == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(s.Folders)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.unsaved = 0
+	return nil
+}
+
+func md5Sum(data []byte) string {
+	return fmt.Sprintf("%x", md5.Sum(data))
+}
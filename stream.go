@@ -0,0 +1,161 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"bytes"
+	"syscall"
+	"unsafe"
+
+	ole "github.com/go-ole/go-ole"
+)
+
+const (
+	sOK      = uintptr(0)
+	eNotImpl = uintptr(0x80004001)
+)
+
+// goStream is a Go-backed COM IStream: MAPIToMIMEStm writes the converted
+// MIME message straight into an in-memory buffer via Write, so
+// extractMessageData no longer has to GlobalLock an HGLOBAL and copy its
+// contents into a fresh Go slice before it can be used. The whole message
+// still lands in s.buf before extractMessageData returns data, since
+// injectHeaders and classify both need the full bytes to rewrite headers
+// and tokenize the body; this cuts one redundant copy per message, not
+// overall peak memory for very large messages. It is reused across
+// messages; call Reset before each conversion.
+type goStream struct {
+	ole.IUnknown
+	ref int32
+	buf bytes.Buffer
+}
+
+// goStreamVtbl mirrors IStreamVtbl's layout. MAPIToMIMEStm only ever needs
+// Write on the way in (Seek/Stat are answered defensively in case it probes
+// the size); the rest are conservative stubs.
+type goStreamVtbl struct {
+	ole.IUnknownVtbl
+	Read         uintptr
+	Write        uintptr
+	Seek         uintptr
+	SetSize      uintptr
+	CopyTo       uintptr
+	Commit       uintptr
+	Revert       uintptr
+	LockRegion   uintptr
+	UnlockRegion uintptr
+	Stat         uintptr
+	Clone        uintptr
+}
+
+var goStreamVtblInstance = goStreamVtbl{
+	IUnknownVtbl: ole.IUnknownVtbl{
+		QueryInterface: syscall.NewCallback(goStreamQueryInterface),
+		AddRef:         syscall.NewCallback(goStreamAddRef),
+		Release:        syscall.NewCallback(goStreamRelease),
+	},
+	Read:         syscall.NewCallback(goStreamRead),
+	Write:        syscall.NewCallback(goStreamWrite),
+	Seek:         syscall.NewCallback(goStreamSeek),
+	SetSize:      syscall.NewCallback(goStreamSetSize),
+	CopyTo:       syscall.NewCallback(goStreamCopyTo),
+	Commit:       syscall.NewCallback(goStreamCommit),
+	Revert:       syscall.NewCallback(goStreamRevert),
+	LockRegion:   syscall.NewCallback(goStreamLockRegion),
+	UnlockRegion: syscall.NewCallback(goStreamUnlockRegion),
+	Stat:         syscall.NewCallback(goStreamStat),
+	Clone:        syscall.NewCallback(goStreamClone),
+}
+
+func newGoStream() *goStream {
+	s := &goStream{ref: 1}
+	s.RawVTable = (*interface{})(unsafe.Pointer(&goStreamVtblInstance))
+	return s
+}
+
+// asIStream reinterprets the goStream as the *IStream type
+// IConverterSession.MAPIToMIMEStm expects; both start with the same
+// RawVTable field, so the COM object layouts line up.
+func (s *goStream) asIStream() *IStream {
+	return (*IStream)(unsafe.Pointer(s))
+}
+
+func (s *goStream) Reset() {
+	s.buf.Reset()
+}
+
+func (s *goStream) Bytes() []byte {
+	return s.buf.Bytes()
+}
+
+func streamFromThis(this uintptr) *goStream {
+	return (*goStream)(unsafe.Pointer(this))
+}
+
+func goStreamQueryInterface(this, riid, ppvObject uintptr) uintptr {
+	if ppvObject != 0 {
+		*(*uintptr)(unsafe.Pointer(ppvObject)) = this
+	}
+	streamFromThis(this).ref++
+	return sOK
+}
+
+func goStreamAddRef(this uintptr) uintptr {
+	s := streamFromThis(this)
+	s.ref++
+	return uintptr(s.ref)
+}
+
+func goStreamRelease(this uintptr) uintptr {
+	s := streamFromThis(this)
+	s.ref--
+	return uintptr(s.ref)
+}
+
+func goStreamRead(this, pv, cb, pcbRead uintptr) uintptr {
+	return eNotImpl
+}
+
+func goStreamWrite(this, pv, cb, pcbWritten uintptr) uintptr {
+	s := streamFromThis(this)
+	if cb > 0 {
+		src := (*[1 << 30]byte)(unsafe.Pointer(pv))[:cb:cb]
+		s.buf.Write(src)
+	}
+	if pcbWritten != 0 {
+		*(*uint32)(unsafe.Pointer(pcbWritten)) = uint32(cb)
+	}
+	return sOK
+}
+
+func goStreamSeek(this, dlibMove, dwOrigin, plibNewPosition uintptr) uintptr {
+	if plibNewPosition != 0 {
+		*(*uint64)(unsafe.Pointer(plibNewPosition)) = uint64(streamFromThis(this).buf.Len())
+	}
+	return sOK
+}
+
+func goStreamSetSize(this, libNewSize uintptr) uintptr { return sOK }
+
+func goStreamCopyTo(this, pstm, cb, pcbRead, pcbWritten uintptr) uintptr { return eNotImpl }
+
+func goStreamCommit(this, grfCommitFlags uintptr) uintptr { return sOK }
+
+func goStreamRevert(this uintptr) uintptr { return sOK }
+
+func goStreamLockRegion(this, libOffset, cb, lockType uintptr) uintptr { return eNotImpl }
+
+func goStreamUnlockRegion(this, libOffset, cb, lockType uintptr) uintptr { return eNotImpl }
+
+// goStreamStat only fills in cbSize (a STATSTG's third field, at offset 16
+// on amd64: an 8 byte pwcsName pointer, then a 4 byte dwType padded to 8).
+func goStreamStat(this, pstatstg, grfStatFlag uintptr) uintptr {
+	if pstatstg != 0 {
+		cbSize := (*uint64)(unsafe.Pointer(pstatstg + 16))
+		*cbSize = uint64(streamFromThis(this).buf.Len())
+	}
+	return sOK
+}
+
+func goStreamClone(this, ppstm uintptr) uintptr { return eNotImpl }